@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"log/slog"
 	"os"
 	"sort"
 	"strings"
@@ -34,16 +36,35 @@ func key(labels models.LabelSet) string {
 	return b.String()
 }
 
+// entry tracks the lifecycle of a single firing key. restored is set when
+// the entry was loaded from disk at startup rather than freshly observed,
+// and is never persisted.
+type entry struct {
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	LastSent  time.Time `json:"lastSent"`
+
+	restored bool
+}
+
 type Manager struct {
-	mu       sync.Mutex
-	state    map[string]time.Time
-	filePath string
+	mu        sync.Mutex
+	state     map[string]*entry
+	filePath  string
+	startTime time.Time
+
+	// dirty marks that LastSeen/LastSent have changed in memory since the
+	// last flushLocked. MarkActive/MarkSent update these in place without
+	// flushing on every call (rule evaluation hits them once per firing
+	// alert per tick); Sweep picks up the pending write on its interval.
+	dirty bool
 }
 
 func NewManager(filePath string) *Manager {
 	return &Manager{
-		state:    make(map[string]time.Time),
-		filePath: filePath,
+		state:     make(map[string]*entry),
+		filePath:  filePath,
+		startTime: time.Now(),
 	}
 }
 
@@ -55,7 +76,13 @@ func (m *Manager) Load() error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &m.state)
+	if err := json.Unmarshal(data, &m.state); err != nil {
+		return err
+	}
+	for _, e := range m.state {
+		e.restored = true
+	}
+	return nil
 }
 
 func (m *Manager) flushLocked() error {
@@ -70,15 +97,23 @@ func (m *Manager) flushLocked() error {
 	return os.Rename(tmp, m.filePath) // atomic replace
 }
 
-// MarkActive sets firstSeen for a key if not already set
+// MarkActive sets firstSeen for a key the first time it's observed and
+// refreshes lastSeen on every observation thereafter. Only a newly-seen key
+// is flushed immediately; refreshing an existing key's lastSeen is cheap and
+// kept in memory, persisted later by the sweeper, since rule evaluation
+// calls this once per firing alert on every tick.
 func (m *Manager) MarkActive(key string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if _, exists := m.state[key]; !exists {
-		m.state[key] = time.Now()
+	now := time.Now()
+	e, exists := m.state[key]
+	if !exists {
+		m.state[key] = &entry{FirstSeen: now, LastSeen: now}
 		return m.flushLocked()
 	}
+	e.LastSeen = now
+	m.dirty = true
 	return nil
 }
 
@@ -94,14 +129,109 @@ func (m *Manager) MarkResolved(key string) error {
 	return nil
 }
 
-// ShouldFire returns true if now >= firstSeen + forDuration
-func (m *Manager) ShouldFire(key string, forDuration time.Duration) bool {
+// MarkSent records that an alert for key was just POSTed to alertmanager,
+// for use by ShouldSend's resend-delay gating. Like MarkActive's lastSeen
+// refresh, this updates in memory and relies on the sweeper to persist it.
+func (m *Manager) MarkSent(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, exists := m.state[key]
+	if !exists {
+		return nil
+	}
+	e.LastSent = time.Now()
+	m.dirty = true
+	return nil
+}
+
+// ShouldFire returns true if key has been continuously active for at least
+// forDuration. If key was restored from disk at startup, it must first be
+// re-observed for min(forDuration, forGracePeriod) since process start
+// before it's allowed to fire, so a long-stopped daemon doesn't immediately
+// re-fire every alert that was active when it last shut down.
+func (m *Manager) ShouldFire(key string, forDuration, forGracePeriod time.Duration) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	first, exists := m.state[key]
+	e, exists := m.state[key]
 	if !exists {
 		return false
 	}
-	return time.Since(first) >= forDuration
+
+	if e.restored {
+		grace := forDuration
+		if forGracePeriod < grace {
+			grace = forGracePeriod
+		}
+		if time.Since(m.startTime) < grace {
+			return false
+		}
+	}
+
+	return time.Since(e.FirstSeen) >= forDuration
+}
+
+// ShouldSend reports whether an already-firing alert for key is due to be
+// re-POSTed to alertmanager, given resendDelay. A resendDelay of zero means
+// "always resend", preserving the pre-resendDelay behavior.
+func (m *Manager) ShouldSend(key string, now time.Time, resendDelay time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, exists := m.state[key]
+	if !exists {
+		return true
+	}
+	if resendDelay <= 0 {
+		return true
+	}
+	return now.Sub(e.LastSent) >= resendDelay
+}
+
+// Sweep deletes entries whose lastSeen is older than retention, so keys for
+// alerts that stopped firing without a clean MarkResolved (e.g. the rule
+// itself was removed) don't accumulate in the state file forever. It also
+// persists any lastSeen/lastSent updates MarkActive/MarkSent accumulated in
+// memory since the last flush, since this is the sweeper's periodic tick.
+func (m *Manager) Sweep(retention time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var swept int
+	for k, e := range m.state {
+		if now.Sub(e.LastSeen) > retention {
+			delete(m.state, k)
+			swept++
+		}
+	}
+	if swept == 0 && !m.dirty {
+		return nil
+	}
+	if swept > 0 {
+		slog.Debug("swept stale state entries", "count", swept)
+	}
+	if err := m.flushLocked(); err != nil {
+		return err
+	}
+	m.dirty = false
+	return nil
+}
+
+// RunSweeper calls Sweep on the given interval until ctx is cancelled.
+func (m *Manager) RunSweeper(ctx context.Context, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Sweep(retention); err != nil {
+				slog.Error("error sweeping state file", "error", err)
+			}
+		}
+	}
 }
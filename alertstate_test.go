@@ -0,0 +1,144 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManager_MarkActiveAndShouldFire(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "state.json"))
+
+	if m.ShouldFire("a", 0, 0) {
+		t.Fatal("ShouldFire should be false for a key that was never observed")
+	}
+
+	if err := m.MarkActive("a"); err != nil {
+		t.Fatalf("MarkActive: %v", err)
+	}
+	if !m.ShouldFire("a", 0, 0) {
+		t.Fatal("ShouldFire should be true once forDuration has elapsed")
+	}
+	if m.ShouldFire("a", time.Hour, 0) {
+		t.Fatal("ShouldFire should be false before forDuration has elapsed")
+	}
+}
+
+func TestManager_ShouldFire_RestoredRequiresGracePeriod(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	m := NewManager(path)
+	if err := m.MarkActive("a"); err != nil {
+		t.Fatalf("MarkActive: %v", err)
+	}
+
+	restored := NewManager(path)
+	if err := restored.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	// firstSeen is old enough to satisfy forDuration on its own; only the
+	// restored-grace-period check against startTime should be gating.
+	restored.state["a"].FirstSeen = time.Now().Add(-2 * time.Hour)
+	restored.startTime = time.Now()
+
+	if restored.ShouldFire("a", time.Hour, time.Hour) {
+		t.Fatal("a restored key must wait out forGracePeriod before firing again")
+	}
+
+	restored.startTime = time.Now().Add(-2 * time.Hour)
+	if !restored.ShouldFire("a", time.Hour, time.Hour) {
+		t.Fatal("a restored key should fire once forGracePeriod has elapsed since startup")
+	}
+}
+
+func TestManager_ShouldSend_ResendDelay(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "state.json"))
+
+	if !m.ShouldSend("a", time.Now(), time.Minute) {
+		t.Fatal("ShouldSend should be true for a key that was never marked active")
+	}
+
+	if err := m.MarkActive("a"); err != nil {
+		t.Fatalf("MarkActive: %v", err)
+	}
+	if !m.ShouldSend("a", time.Now(), time.Minute) {
+		t.Fatal("ShouldSend should be true before the first MarkSent")
+	}
+
+	if err := m.MarkSent("a"); err != nil {
+		t.Fatalf("MarkSent: %v", err)
+	}
+	now := time.Now()
+	if m.ShouldSend("a", now, time.Minute) {
+		t.Fatal("ShouldSend should be false immediately after MarkSent with a resendDelay")
+	}
+	if !m.ShouldSend("a", now.Add(2*time.Minute), time.Minute) {
+		t.Fatal("ShouldSend should be true again once resendDelay has elapsed")
+	}
+	if !m.ShouldSend("a", now, 0) {
+		t.Fatal("a zero resendDelay should always allow resending")
+	}
+}
+
+func TestManager_Sweep(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "state.json"))
+	if err := m.MarkActive("stale"); err != nil {
+		t.Fatalf("MarkActive: %v", err)
+	}
+	if err := m.MarkActive("fresh"); err != nil {
+		t.Fatalf("MarkActive: %v", err)
+	}
+
+	m.state["stale"].LastSeen = time.Now().Add(-2 * time.Hour)
+
+	if err := m.Sweep(time.Hour); err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+
+	if _, exists := m.state["stale"]; exists {
+		t.Fatal("Sweep should delete entries older than retention")
+	}
+	if _, exists := m.state["fresh"]; !exists {
+		t.Fatal("Sweep should not delete entries within retention")
+	}
+}
+
+func TestManager_Sweep_PersistsDirtyStateEvenWithoutSweeping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	m := NewManager(path)
+	if err := m.MarkActive("a"); err != nil {
+		t.Fatalf("MarkActive: %v", err)
+	}
+	firstSeen := m.state["a"].LastSeen
+
+	// A second MarkActive on an existing key only updates lastSeen in
+	// memory; it must not be flushed until Sweep runs. Sleep briefly first
+	// so the refreshed lastSeen is distinguishable from firstSeen.
+	time.Sleep(time.Millisecond)
+	if err := m.MarkActive("a"); err != nil {
+		t.Fatalf("MarkActive: %v", err)
+	}
+	refreshed := m.state["a"].LastSeen
+	if refreshed.Equal(firstSeen) {
+		t.Fatal("MarkActive should still refresh lastSeen in memory")
+	}
+
+	reloaded := NewManager(path)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reloaded.state["a"].LastSeen.Equal(firstSeen) {
+		t.Fatal("the refreshed lastSeen should not have been flushed to disk yet")
+	}
+
+	if err := m.Sweep(time.Hour); err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+
+	reloaded = NewManager(path)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reloaded.state["a"].LastSeen.Equal(refreshed) {
+		t.Fatal("Sweep should persist the in-memory lastSeen update")
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/goccy/go-yaml"
+)
+
+// ruleRunner tracks a single running rule goroutine so that a config reload
+// can cancel and replace it independently of every other rule.
+type ruleRunner struct {
+	rule   AlertRule
+	cancel context.CancelFunc
+}
+
+// configLoaderMetrics backs the config_last_reload_successful and
+// config_last_reload_success_timestamp_seconds gauges.
+type configLoaderMetrics struct {
+	mu          sync.Mutex
+	successful  bool
+	lastSuccess time.Time
+}
+
+func (m *configLoaderMetrics) recordSuccess() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.successful = true
+	m.lastSuccess = time.Now()
+}
+
+func (m *configLoaderMetrics) recordFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.successful = false
+}
+
+// ConfigLoader watches a YAML config file with fsnotify and reconciles the
+// running rule set against it on every write, so operators can edit rules
+// in-place instead of restarting the daemon.
+type ConfigLoader struct {
+	path   string
+	driver string
+
+	// startRule launches a rule's evaluation loop under ctx and returns
+	// without blocking, since it is called with mu held; it must arrange for
+	// its own wg.Add/Done bookkeeping, and the loop it launches must exit
+	// once ctx is cancelled.
+	startRule func(ctx context.Context, r AlertRule)
+
+	mu      sync.Mutex
+	runners map[string]*ruleRunner
+
+	// runCtx is the context given to Start. Once set, every reload starts
+	// rules under it, whichever caller triggered the reload.
+	runCtx context.Context
+
+	metrics configLoaderMetrics
+}
+
+// NewConfigLoader builds a loader for path. startRule is called once per
+// rule that needs to be (re)started.
+func NewConfigLoader(path, driver string, startRule func(ctx context.Context, r AlertRule)) *ConfigLoader {
+	return &ConfigLoader{
+		path:      path,
+		driver:    driver,
+		startRule: startRule,
+		runners:   make(map[string]*ruleRunner),
+	}
+}
+
+// Load parses and validates the config file without starting anything.
+// Callers use this for the initial, fatal-on-error load at startup.
+func (c *ConfigLoader) Load() (Config, error) {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+
+	conf := Config{}
+	decoder := yaml.NewDecoder(f, yaml.DisallowUnknownField(), yaml.UseJSONUnmarshaler())
+	if err := decoder.Decode(&conf); err != nil && !errors.Is(err, io.EOF) {
+		return Config{}, err
+	}
+
+	for _, r := range conf.Rules {
+		if r.EvaluateFreq <= 0 {
+			return Config{}, fmt.Errorf("rule %q: evaluateFreq must be positive", r.Name)
+		}
+		if err := validateNotifyChannel(r, c.driver); err != nil {
+			return Config{}, err
+		}
+		if _, err := compileRule(r); err != nil {
+			return Config{}, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+	}
+
+	return conf, nil
+}
+
+// Start launches a runner for every rule in conf and begins watching
+// c.path, reconciling the running rule set on every subsequent write.
+func (c *ConfigLoader) Start(ctx context.Context, conf Config) error {
+	c.mu.Lock()
+	c.runCtx = ctx
+	for _, r := range conf.Rules {
+		c.startLocked(ctx, r)
+	}
+	c.mu.Unlock()
+	c.metrics.recordSuccess()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating config watcher: %w", err)
+	}
+	if err := watcher.Add(c.path); err != nil {
+		watcher.Close()
+		return fmt.Errorf("watching %s: %w", c.path, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+					// Atomic-save editors and ConfigMap symlink swaps replace
+					// the watched inode, which inotify drops from its watch
+					// set along with the event. Re-add the path so future
+					// edits keep firing; the new inode may not exist yet at
+					// event time, so retry briefly.
+					c.rewatch(watcher)
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					c.reload(ctx)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("config watcher error", "error", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// rewatch re-adds c.path to watcher after the kernel drops it following a
+// Remove or Rename event. The replacement file (from a rename, an atomic
+// editor save, or a ConfigMap symlink swap) may not exist at the instant the
+// event arrives, so this retries briefly before giving up.
+func (c *ConfigLoader) rewatch(watcher *fsnotify.Watcher) {
+	const (
+		attempts = 5
+		delay    = 50 * time.Millisecond
+	)
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = watcher.Add(c.path); err == nil {
+			return
+		}
+		time.Sleep(delay)
+	}
+	slog.Error("config watcher: failed to re-add path after remove/rename", "path", c.path, "error", err)
+}
+
+// Reload triggers an immediate reconciliation against the file's current
+// contents, the same as an fsnotify write event, for callers like the
+// /-/reload HTTP endpoint. It returns this call's own outcome, independent
+// of any reload a concurrent fsnotify event may be driving. Once Start has
+// run, rules are (re)started under Start's context rather than ctx, so they
+// keep running after a short-lived ctx such as an HTTP request's ends.
+func (c *ConfigLoader) Reload(ctx context.Context) error {
+	c.mu.Lock()
+	if c.runCtx != nil {
+		ctx = c.runCtx
+	}
+	c.mu.Unlock()
+	return c.reload(ctx)
+}
+
+// reload re-parses the config file and diffs it against the running rule
+// set, starting added rules, stopping removed ones, and restarting modified
+// ones. A failed reload logs the error and leaves the previous config live.
+func (c *ConfigLoader) reload(ctx context.Context) error {
+	conf, err := c.Load()
+	if err != nil {
+		slog.Error("config reload failed, keeping previous config live", "path", c.path, "error", err)
+		c.metrics.recordFailure()
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := make(map[string]struct{}, len(conf.Rules))
+	for _, r := range conf.Rules {
+		seen[r.Name] = struct{}{}
+
+		existing, ok := c.runners[r.Name]
+		switch {
+		case !ok:
+			slog.Info("config reload: starting new rule", "name", r.Name)
+			c.startLocked(ctx, r)
+		case !reflect.DeepEqual(existing.rule, r):
+			slog.Info("config reload: restarting changed rule", "name", r.Name)
+			existing.cancel()
+			c.startLocked(ctx, r)
+		}
+	}
+
+	for name, runner := range c.runners {
+		if _, ok := seen[name]; !ok {
+			slog.Info("config reload: stopping removed rule", "name", name)
+			runner.cancel()
+			delete(c.runners, name)
+		}
+	}
+
+	c.metrics.recordSuccess()
+	slog.Info("config reloaded", "path", c.path, "rules", len(conf.Rules))
+	return nil
+}
+
+// startLocked must be called with c.mu held.
+func (c *ConfigLoader) startLocked(ctx context.Context, r AlertRule) {
+	runCtx, cancel := context.WithCancel(ctx)
+	c.runners[r.Name] = &ruleRunner{rule: r, cancel: cancel}
+	c.startRule(runCtx, r)
+}
+
+// LastReloadSuccessful reports config_last_reload_successful.
+func (c *ConfigLoader) LastReloadSuccessful() bool {
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+	return c.metrics.successful
+}
+
+// LastReloadSuccessTimestamp reports
+// config_last_reload_success_timestamp_seconds.
+func (c *ConfigLoader) LastReloadSuccessTimestamp() time.Time {
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+	return c.metrics.lastSuccess
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// startRecorder is a startRule stub that records which rules have been
+// (re)started, and the ctx each start was given, for assertions without
+// actually running anything.
+type startRecorder struct {
+	mu      sync.Mutex
+	started []string
+	ctxs    []context.Context
+}
+
+func (s *startRecorder) startRule(ctx context.Context, r AlertRule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = append(s.started, r.Name)
+	s.ctxs = append(s.ctxs, ctx)
+}
+
+func (s *startRecorder) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]string, len(s.started))
+	copy(out, s.started)
+	return out
+}
+
+func writeConfig(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+}
+
+const ruleA = `rules:
+  - name: a
+    query: "select 1"
+    evaluateFreq: 10s
+`
+
+const ruleATweaked = `rules:
+  - name: a
+    query: "select 1"
+    evaluateFreq: 20s
+`
+
+const ruleAandB = `rules:
+  - name: a
+    query: "select 1"
+    evaluateFreq: 10s
+  - name: b
+    query: "select 2"
+    evaluateFreq: 10s
+`
+
+func TestConfigLoader_Reload_StartsNewRule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, ruleA)
+
+	rec := &startRecorder{}
+	c := NewConfigLoader(path, "pgx", rec.startRule)
+
+	if err := c.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if got := rec.names(); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("expected rule %q to be started, got %v", "a", got)
+	}
+	if _, ok := c.runners["a"]; !ok {
+		t.Fatal("runners should track the newly started rule")
+	}
+}
+
+func TestConfigLoader_Reload_RestartsChangedRule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, ruleA)
+
+	rec := &startRecorder{}
+	c := NewConfigLoader(path, "pgx", rec.startRule)
+	if err := c.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	firstCtx := rec.ctxs[0]
+
+	writeConfig(t, path, ruleATweaked)
+	if err := c.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if got := rec.names(); len(got) != 2 || got[1] != "a" {
+		t.Fatalf("a changed rule should be restarted once, got %v", got)
+	}
+	if c.runners["a"].rule.EvaluateFreq.String() != "20s" {
+		t.Fatalf("runner should track the new rule definition, got %v", c.runners["a"].rule.EvaluateFreq)
+	}
+	if firstCtx.Err() != context.Canceled {
+		t.Fatal("restarting a changed rule should cancel its previous run context")
+	}
+}
+
+func TestConfigLoader_Reload_LeavesUnchangedRuleRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, ruleA)
+
+	rec := &startRecorder{}
+	c := NewConfigLoader(path, "pgx", rec.startRule)
+	if err := c.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	// Re-applying the identical config should not restart rule "a".
+	if err := c.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if got := rec.names(); len(got) != 1 {
+		t.Fatalf("an unchanged rule should not be restarted, got %v", got)
+	}
+}
+
+func TestConfigLoader_Reload_StopsRemovedRule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, ruleAandB)
+
+	rec := &startRecorder{}
+	c := NewConfigLoader(path, "pgx", rec.startRule)
+	if err := c.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	writeConfig(t, path, ruleA)
+	if err := c.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if _, ok := c.runners["b"]; ok {
+		t.Fatal("a removed rule should be dropped from runners")
+	}
+	if _, ok := c.runners["a"]; !ok {
+		t.Fatal("rule a should remain running")
+	}
+}
+
+func TestConfigLoader_Reload_KeepsPreviousConfigOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, ruleA)
+
+	rec := &startRecorder{}
+	c := NewConfigLoader(path, "pgx", rec.startRule)
+	if err := c.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	writeConfig(t, path, "rules:\n  - name: bad\n    query: \"select 1\"\n    evaluateFreq: 0s\n")
+	if err := c.Reload(context.Background()); err == nil {
+		t.Fatal("Reload should reject evaluateFreq <= 0")
+	}
+
+	if _, ok := c.runners["a"]; !ok {
+		t.Fatal("a failed reload should leave the previously running rule in place")
+	}
+	if c.LastReloadSuccessful() {
+		t.Fatal("LastReloadSuccessful should reflect the failed reload")
+	}
+}
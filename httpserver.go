@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the daemon's rule- and alertmanager-facing Prometheus
+// collectors. go_* and process_* collectors are registered automatically by
+// the client library against the default registerer.
+type Metrics struct {
+	ruleEvaluationsTotal *prometheus.CounterVec
+	evaluationDuration   *prometheus.HistogramVec
+	queryRows            *prometheus.GaugeVec
+	alertsFiring         *prometheus.GaugeVec
+	amNotifications      *prometheus.CounterVec
+	amLatency            *prometheus.GaugeVec
+}
+
+// NewMetrics registers and returns the daemon's metric collectors.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		ruleEvaluationsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "sqlam_rule_evaluations_total",
+			Help: "Total number of rule evaluations, by result status.",
+		}, []string{"rule", "status"}),
+		evaluationDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "sqlam_rule_evaluation_duration_seconds",
+			Help: "Time spent evaluating a rule's query.",
+		}, []string{"rule"}),
+		queryRows: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sqlam_query_rows",
+			Help: "Number of rows returned by a rule's most recent query.",
+		}, []string{"rule"}),
+		alertsFiring: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sqlam_alerts_firing",
+			Help: "Number of alerts a rule queued to the notifier on its most recent evaluation.",
+		}, []string{"rule"}),
+		amNotifications: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "sqlam_alertmanager_notifications_total",
+			Help: "Total number of alert batch deliveries to the alertmanagers, by result status.",
+		}, []string{"status"}),
+		amLatency: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sqlam_alertmanager_latency_seconds",
+			Help: "Latency of the most recent POST to each alertmanager.",
+		}, []string{"alertmanager"}),
+	}
+}
+
+// observeEvaluation records the outcome of a single rule evaluation.
+func (m *Metrics) observeEvaluation(rule, status string, duration time.Duration, rows, firing int) {
+	m.ruleEvaluationsTotal.WithLabelValues(rule, status).Inc()
+	m.evaluationDuration.WithLabelValues(rule).Observe(duration.Seconds())
+	m.queryRows.WithLabelValues(rule).Set(float64(rows))
+	m.alertsFiring.WithLabelValues(rule).Set(float64(firing))
+}
+
+// recordNotification records the outcome of one notifier flush.
+func (m *Metrics) recordNotification(success bool) {
+	status := "success"
+	if !success {
+		status = "failure"
+	}
+	m.amNotifications.WithLabelValues(status).Inc()
+}
+
+// recordLatency records the latency of a single POST to target.
+func (m *Metrics) recordLatency(target string, d time.Duration) {
+	m.amLatency.WithLabelValues(target).Set(d.Seconds())
+}
+
+// registerRuntimeGauges wires the config loader and notifier's internal
+// state into Prometheus GaugeFuncs. Call once both are constructed.
+func registerRuntimeGauges(loader *ConfigLoader, notif *Notifier) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful.",
+	}, func() float64 {
+		if loader.LastReloadSuccessful() {
+			return 1
+		}
+		return 0
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload.",
+	}, func() float64 {
+		t := loader.LastReloadSuccessTimestamp()
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.Unix())
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "sqlam_notifier_queue_length",
+		Help: "Number of distinct alerts currently queued in the notifier.",
+	}, func() float64 {
+		return float64(notif.QueueLength())
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "sqlam_notifier_alerts_dropped_total",
+		Help: "Total number of alerts dropped because the notifier queue was full.",
+	}, func() float64 {
+		return float64(notif.Dropped())
+	})
+}
+
+// Server is the daemon's embedded observability surface: /metrics,
+// /healthz, and /-/reload.
+type Server struct {
+	addr   string
+	db     *sql.DB
+	loader *ConfigLoader
+
+	configLoaded atomic.Bool
+}
+
+// NewServer builds a Server. It is only ever constructed after main has
+// already loaded the config successfully, so configLoaded is seeded true
+// rather than tracked live.
+func NewServer(addr string, db *sql.DB, loader *ConfigLoader) *Server {
+	s := &Server{addr: addr, db: db, loader: loader}
+	s.configLoaded.Store(true)
+	return s
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/-/reload", s.handleReload)
+	return mux
+}
+
+// handleHealthz returns 200 once the config has loaded and the database is
+// reachable.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !s.configLoaded.Load() {
+		http.Error(w, "config not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	if err := s.db.PingContext(r.Context()); err != nil {
+		http.Error(w, fmt.Sprintf("db ping failed: %s", err), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReload triggers an immediate config reload, the same reconciliation
+// an fsnotify write event would cause.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.loader.Reload(r.Context()); err != nil {
+		http.Error(w, fmt.Sprintf("reload failed: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Run starts the HTTP server and blocks until ctx is cancelled.
+func (s *Server) Run(ctx context.Context) error {
+	srv := &http.Server{Addr: s.addr, Handler: s.handler()}
+
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("web server listening", "address", s.addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	case err := <-errCh:
+		return err
+	}
+}
@@ -3,10 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"flag"
-	"fmt"
-	"io"
 	"log"
 	"log/slog"
 	"os"
@@ -17,33 +14,40 @@ import (
 	"time"
 
 	"github.com/go-openapi/strfmt"
-	"github.com/goccy/go-yaml"
-	"github.com/prometheus/alertmanager/api/v2/client"
-	"github.com/prometheus/alertmanager/api/v2/client/alert"
 	"github.com/prometheus/alertmanager/api/v2/models"
 )
 
 type AlertRule struct {
-	Name           string        `yaml:"name"`
-	Query          string        `yaml:"query"`
-	EvaluateFreq   time.Duration `yaml:"evaluateFreq"`
-	LabelCols      []string      `yaml:"labelCols"`
-	AnnotationCols []string      `yaml:"annotationCols"`
-	For            time.Duration `yaml:"for"`
+	Name           string            `yaml:"name"`
+	Query          string            `yaml:"query"`
+	EvaluateFreq   time.Duration     `yaml:"evaluateFreq"`
+	LabelCols      []string          `yaml:"labelCols"`
+	AnnotationCols []string          `yaml:"annotationCols"`
+	Labels         map[string]string `yaml:"labels"`
+	Annotations    map[string]string `yaml:"annotations"`
+	For            time.Duration     `yaml:"for"`
+	NotifyChannel  string            `yaml:"notifyChannel"`
+	ResendDelay    time.Duration     `yaml:"resendDelay"`
+	ForGracePeriod time.Duration     `yaml:"forGracePeriod"`
+	ResolveTimeout time.Duration     `yaml:"resolveTimeout"`
 }
 
 type Config struct {
-	Db    string      `yaml:"db"`
-	Rules []AlertRule `yaml:"rules"`
+	Db            string               `yaml:"db"`
+	Rules         []AlertRule          `yaml:"rules"`
+	Alertmanagers []AlertmanagerConfig `yaml:"alertmanagers"`
 }
 
 func main() {
 	driver := flag.String("driver", "pgx", "sql driver to use")
-	amHost := flag.String("alertManagerHost", "localhost", "hostname for alert manager")
-	amPath := flag.String("alertManagerPath", "/api/v2/", "alert manager v2 api path")
 	configPath := flag.String("config", "./config.yaml", "path to config")
-	maxRequestTimeout := flag.Duration("maxRequestTimeout", time.Second*5, "request to alertmanager timeout")
+	maxRequestTimeout := flag.Duration("maxRequestTimeout", time.Second*5, "default per-alertmanager request timeout")
+	notifierQueueCap := flag.Int("notifierQueueCap", 10_000, "max number of distinct alerts held in the notifier queue")
+	notifierFlushInterval := flag.Duration("notifierFlushInterval", 5*time.Second, "how often the notifier drains its queue to the alertmanagers")
 	statePath := flag.String("state", "./alertstate.json", "path of local state file")
+	stateSweepInterval := flag.Duration("stateSweepInterval", time.Hour, "how often to garbage-collect stale state entries")
+	stateRetention := flag.Duration("stateRetention", 7*24*time.Hour, "how long a state entry may go unseen before it's garbage-collected")
+	webListenAddress := flag.String("webListenAddress", ":9090", "address to serve /metrics, /healthz, and /-/reload on")
 	debug := flag.Bool("debug", false, "show debug logs")
 	flag.Parse()
 
@@ -66,27 +70,21 @@ func main() {
 		)
 	}
 
-	// Unmarshal the config file
-	conf := Config{}
-	f, err := os.Open(*configPath)
+	// Load and validate the config file
+	loader := NewConfigLoader(*configPath, *driver, nil)
+	conf, err := loader.Load()
 	if err != nil {
-		log.Fatalf("error opening config file: %s", err)
+		log.Fatalf("error loading config: %s", err)
 	}
 
-	decoder := yaml.NewDecoder(f, yaml.DisallowUnknownField(), yaml.UseJSONUnmarshaler())
-	err = decoder.Decode(&conf)
-	f.Close()
-	if err != nil && !errors.Is(err, io.EOF) {
-		log.Fatalf("error parsing yaml: %s", err)
-	}
+	metrics := NewMetrics()
 
-	// Set up the alertmanager client
-	amClient := client.NewHTTPClientWithConfig(
-		strfmt.Default,
-		client.DefaultTransportConfig().
-			WithHost(*amHost).
-			WithBasePath(*amPath),
-	)
+	// Set up the notifier that owns all alertmanager I/O
+	notif, err := NewNotifier(conf.Alertmanagers, *maxRequestTimeout, *notifierQueueCap, metrics)
+	if err != nil {
+		log.Fatalf("error configuring notifier: %s", err)
+	}
+	registerRuntimeGauges(loader, notif)
 
 	// Set up signal-based cancellation behavior
 	ctx, cancel := context.WithCancel(context.Background())
@@ -95,6 +93,18 @@ func main() {
 
 	wg := &sync.WaitGroup{}
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		notif.Run(ctx, *notifierFlushInterval)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		store.RunSweeper(ctx, *stateSweepInterval, *stateRetention)
+	}()
+
 	// Open and configure a connection to the target database
 	db, err := sql.Open(*driver, conf.Db)
 	if err != nil {
@@ -115,233 +125,261 @@ func main() {
 		log.Fatalf("DB connection test failed: %s", err)
 	}
 
-	// Create a goroutine-per-rule that will evaluate each rule
-	// and post updates to alertmanager as state changes
-	for _, r := range conf.Rules {
+	server := NewServer(*webListenAddress, db, loader)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := server.Run(ctx); err != nil {
+			slog.Error("web server stopped", "error", err)
+		}
+	}()
+
+	// runRule evaluates a single rule and posts updates to alertmanager as
+	// its state changes; it runs until ctx is cancelled, which the config
+	// loader does individually per-rule on add, remove, or edit.
+	runRule := func(ctx context.Context, r AlertRule) {
 		wg.Add(1)
-		go func(r AlertRule) {
+		go func() {
 			defer wg.Done()
 			slog.Info("registered rule", "name", r.Name)
 
+			cr, err := compileRule(r)
+			if err != nil {
+				// Config load already validates every rule's templates, so
+				// this should be unreachable; fail loud rather than run
+				// with no labels/annotations if it ever happens.
+				slog.Error("error compiling templates", "name", r.Name, "error", err)
+				return
+			}
+
 			ticker := time.NewTicker(r.EvaluateFreq)
 			defer ticker.Stop()
 
-			for {
-				select {
-				case <-ctx.Done():
-					slog.Info("rule stopped", "name", r.Name)
-					return
-				case <-ticker.C:
-					slog.Debug("executing query",
-						"name", r.Name,
-						"query", r.Query,
-					)
+			var notifications <-chan struct{}
+			if r.NotifyChannel != "" {
+				listener := NewPgNotifyListener(conf.Db, r.NotifyChannel)
+				notifications = listener.Notifications()
 
-					// Execute the rule query and parse results to
-					// create a list of firingAlerts.
-					firingAlerts := models.PostableAlerts{}
-					func() {
-						queryCtx, cancel := context.WithTimeout(ctx, r.EvaluateFreq/2)
-						defer cancel()
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					listener.Run(ctx)
+				}()
+			}
 
-						rows, err := db.QueryContext(queryCtx, r.Query)
-						if err != nil {
-							slog.Error("query failed",
-								"name", r.Name,
-								"error", err.Error())
-							return
-						}
-						defer rows.Close()
+			evaluate := func() {
+				start := time.Now()
+				status := "success"
+				var rowCount, firingCount int
+				defer func() {
+					metrics.observeEvaluation(r.Name, status, time.Since(start), rowCount, firingCount)
+				}()
+
+				slog.Debug("executing query",
+					"name", r.Name,
+					"query", r.Query,
+				)
+
+				// Execute the rule query and parse results to
+				// create a list of firingAlerts.
+				firingAlerts := models.PostableAlerts{}
+				queryErr := func() error {
+					queryCtx, cancel := context.WithTimeout(ctx, r.EvaluateFreq/2)
+					defer cancel()
+
+					rows, err := db.QueryContext(queryCtx, r.Query)
+					if err != nil {
+						slog.Error("query failed",
+							"name", r.Name,
+							"error", err.Error())
+						return err
+					}
+					defer rows.Close()
 
-						cols, err := rows.Columns()
-						if err != nil {
-							slog.Error("error getting columns",
-								"name", r.Name,
-								"error", err)
-							return
-						}
+					cols, err := rows.Columns()
+					if err != nil {
+						slog.Error("error getting columns",
+							"name", r.Name,
+							"error", err)
+						return err
+					}
 
-						colSet := map[string]struct{}{}
-						for _, c := range cols {
-							colSet[c] = struct{}{}
-						}
-						for _, c := range append(r.LabelCols, r.AnnotationCols...) {
-							if _, ok := colSet[c]; !ok {
-								slog.Warn("column missing from query result", "name", r.Name, "column", c)
-							}
+					colSet := map[string]struct{}{}
+					for _, c := range cols {
+						colSet[c] = struct{}{}
+					}
+					for _, c := range append(r.LabelCols, r.AnnotationCols...) {
+						if _, ok := colSet[c]; !ok {
+							slog.Warn("column missing from query result", "name", r.Name, "column", c)
 						}
+					}
 
-						for rows.Next() {
-							raw := make([]sql.RawBytes, len(cols))
-							ptrs := make([]any, len(cols))
-							for i := range raw {
-								ptrs[i] = &raw[i]
-							}
-
-							if err = rows.Scan(ptrs...); err != nil {
-								slog.Error("error scanning row",
-									"name", r.Name,
-									"error", err.Error())
-								return
-							}
-
-							row := make(map[string]string, len(cols))
-							for i, col := range cols {
-								if raw[i] == nil {
-									row[col] = ""
-								} else {
-									row[col] = string(raw[i])
-								}
-							}
-
-							annotations := map[string]string{}
-							labels := map[string]string{}
+					for rows.Next() {
+						raw := make([]sql.RawBytes, len(cols))
+						ptrs := make([]any, len(cols))
+						for i := range raw {
+							ptrs[i] = &raw[i]
+						}
 
-							for _, col := range r.AnnotationCols {
-								val := row[col]
-								if val != "" {
-									annotations[col] = val
-								}
-							}
+						if err = rows.Scan(ptrs...); err != nil {
+							slog.Error("error scanning row",
+								"name", r.Name,
+								"error", err.Error())
+							return err
+						}
 
-							for _, col := range r.LabelCols {
-								val := row[col]
-								if val != "" {
-									labels[col] = val
-								}
+						row := make(map[string]string, len(cols))
+						for i, col := range cols {
+							if raw[i] == nil {
+								row[col] = ""
+							} else {
+								row[col] = string(raw[i])
 							}
-							labels["alertname"] = r.Name
-
-							firingAlerts = append(firingAlerts, &models.PostableAlert{
-								Annotations: annotations,
-								StartsAt:    strfmt.DateTime(time.Now()),
-								Alert: models.Alert{
-									Labels: labels,
-								},
-							})
 						}
 
-						err = rows.Err()
-						if err != nil {
-							slog.Error("error processing query data",
-								"name", r.Name,
-								"error", err.Error(),
-							)
-							return
-						}
-					}()
+						labels, annotations := cr.Execute(r.Name, TemplateData{
+							Row:   row,
+							Query: r.Query,
+							Now:   time.Now(),
+						})
+						labels["alertname"] = r.Name
+
+						firingAlerts = append(firingAlerts, &models.PostableAlert{
+							Annotations: annotations,
+							StartsAt:    strfmt.DateTime(time.Now()),
+							Alert: models.Alert{
+								Labels: labels,
+							},
+						})
+					}
 
-					if len(firingAlerts) == 0 {
-						slog.Debug("no alerts found",
+					if err := rows.Err(); err != nil {
+						slog.Error("error processing query data",
 							"name", r.Name,
+							"error", err.Error(),
 						)
-						return
+						return err
 					}
+					return nil
+				}()
 
-					slog.Info("alerts found",
+				rowCount = len(firingAlerts)
+				if queryErr != nil {
+					status = "error"
+					return
+				}
+
+				if len(firingAlerts) == 0 {
+					slog.Debug("no alerts found",
 						"name", r.Name,
-						"count", len(firingAlerts),
 					)
+					return
+				}
 
-					// Mark active alerts in store and
-					// drop any alerts that have not hit the "for" threshold
-					filteredAlerts := models.PostableAlerts{}
-					for _, alert := range firingAlerts {
-						k := key(alert.Labels)
-						store.MarkActive(k)
-						if store.ShouldFire(k, r.For) {
-							filteredAlerts = append(filteredAlerts, alert)
-						}
-					}
+				slog.Info("alerts found",
+					"name", r.Name,
+					"count", len(firingAlerts),
+				)
 
-					// Get existing alerts from alertmanager
-					getCtx, cancel := context.WithTimeout(ctx, *maxRequestTimeout)
+				// Mark active alerts in store and drop any that haven't hit
+				// the "for" threshold or are being throttled by resendDelay.
+				resolveTimeout := r.ResolveTimeout
+				if resolveTimeout == 0 {
+					resolveTimeout = 3 * r.EvaluateFreq
+				}
 
-					active := true
-					params := alert.NewGetAlertsParams().
-						WithContext(getCtx).
-						WithActive(&active).
-						WithFilter([]string{
-							fmt.Sprintf("alertname=%s", r.Name),
-						})
+				now := time.Now()
+				filteredAlerts := models.PostableAlerts{}
+				for _, alert := range firingAlerts {
+					k := key(alert.Labels)
+					store.MarkActive(k)
 
-					resp, err := amClient.Alert.GetAlerts(params)
-					cancel()
-					if err != nil {
-						slog.Error("failed to get active alerts",
-							"name", r.Name,
-							"error", err)
-						return
+					if !store.ShouldFire(k, r.For, r.ForGracePeriod) {
+						continue
 					}
-					existingAlerts := resp.Payload
-
-					// We care about alerts in 3 buckets:
-					// 1. New alerts
-					// 2. Old alerts that are still firing
-					// 3. Old alerts that have stopped firing
-					//
-					// We already have alerts from buckets 1 and 2 in the
-					// firingAlerts slice. We need to iterate through each
-					// existingAlert, compare it to each firingAlert,
-					// and append it to firingAlert (with an end time) if it
-					// is no longer firing.
-					for _, existing := range existingAlerts {
-						var found bool
-						for idx := range firingAlerts {
-							// todo: use key func here once we can cache the results
-							if reflect.DeepEqual(existing.Labels, firingAlerts[idx].Labels) {
-								// If we find that the alert is still firing, we
-								// need to ensure that its StartsAt matches the old alert
-								// for consistency.
-								firingAlerts[idx].StartsAt = *existing.StartsAt
-								found = true
-								break
-							}
-						}
-						if !found {
-							filteredAlerts = append(filteredAlerts, &models.PostableAlert{
-								Alert:    existing.Alert,
-								StartsAt: *existing.StartsAt,
-								EndsAt:   strfmt.DateTime(time.Now()),
-							})
-							store.MarkResolved(key(existing.Labels))
-						}
+					if !store.ShouldSend(k, now, r.ResendDelay) {
+						continue
 					}
 
-					// Post all alerts to alertmanager
-					func() {
-						postCtx, cancel := context.WithTimeout(ctx, *maxRequestTimeout)
-						defer cancel()
+					// Refresh EndsAt so alertmanager doesn't auto-resolve
+					// the alert before our next resend.
+					alert.EndsAt = strfmt.DateTime(now.Add(resolveTimeout))
+					filteredAlerts = append(filteredAlerts, alert)
+				}
 
-						params := alert.NewPostAlertsParams().
-							WithContext(postCtx).
-							WithAlerts(filteredAlerts)
+				// Get existing alerts from alertmanager
+				existingAlerts, err := notif.ActiveAlerts(ctx, r.Name)
+				if err != nil {
+					slog.Error("failed to get active alerts",
+						"name", r.Name,
+						"error", err)
+					status = "error"
+					return
+				}
 
-						resp, err := amClient.Alert.PostAlerts(params)
-						if err != nil {
-							slog.Error("failed to send alerts",
-								"name", r.Name,
-								"count", len(filteredAlerts))
-							return
+				// We care about alerts in 3 buckets:
+				// 1. New alerts
+				// 2. Old alerts that are still firing
+				// 3. Old alerts that have stopped firing
+				//
+				// We already have alerts from buckets 1 and 2 in the
+				// firingAlerts slice. We need to iterate through each
+				// existingAlert, compare it to each firingAlert,
+				// and append it to firingAlert (with an end time) if it
+				// is no longer firing.
+				for _, existing := range existingAlerts {
+					var found bool
+					for idx := range firingAlerts {
+						// todo: use key func here once we can cache the results
+						if reflect.DeepEqual(existing.Labels, firingAlerts[idx].Labels) {
+							// If we find that the alert is still firing, we
+							// need to ensure that its StartsAt matches the old alert
+							// for consistency.
+							firingAlerts[idx].StartsAt = *existing.StartsAt
+							found = true
+							break
 						}
+					}
+					if !found {
+						filteredAlerts = append(filteredAlerts, &models.PostableAlert{
+							Alert:    existing.Alert,
+							StartsAt: *existing.StartsAt,
+							EndsAt:   strfmt.DateTime(time.Now()),
+						})
+						store.MarkResolved(key(existing.Labels))
+					}
+				}
 
-						if resp.IsSuccess() {
-							slog.Info("alerts sent",
-								"name", r.Name,
-								"count", len(filteredAlerts))
-						} else {
-							slog.Error("failed to send alerts",
-								"name", r.Name,
-								"count", len(filteredAlerts),
-								"code", resp.Code(),
-								"error", resp.Error(),
-							)
-						}
-					}()
+				// Queue alerts for the notifier; it owns batching, retry,
+				// and fan-out to every configured alertmanager. MarkSent
+				// only fires once the notifier reports an actual ACK, so a
+				// resendDelay can't be burned on an alert that never left
+				// the queue.
+				firingCount = len(filteredAlerts)
+				notif.Enqueue(filteredAlerts, func(k string) { store.MarkSent(k) })
+			}
+
+			for {
+				select {
+				case <-ctx.Done():
+					slog.Info("rule stopped", "name", r.Name)
+					return
+				case <-ticker.C:
+					evaluate()
+				case <-notifications:
+					// notifyChannel fired; the ticker remains a safety-net
+					// floor in case a NOTIFY is ever missed.
+					evaluate()
 				}
 			}
+		}()
+	}
 
-		}(r)
+	// Hand runRule to the config loader, which starts every rule from the
+	// initial config and then reconciles the running set on every reload.
+	loader.startRule = runRule
+	if err := loader.Start(ctx, conf); err != nil {
+		log.Fatalf("error starting config watcher: %s", err)
 	}
 
 	sig := <-sigs
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	httptransport "github.com/go-openapi/runtime/client"
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/client"
+	"github.com/prometheus/alertmanager/api/v2/client/alert"
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+// BasicAuth carries HTTP basic auth credentials for an Alertmanager target.
+type BasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// TLSConfig carries client TLS settings for an Alertmanager target.
+type TLSConfig struct {
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	CAFile             string `yaml:"caFile"`
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+}
+
+// AlertmanagerConfig describes a single Alertmanager endpoint to fan out to.
+type AlertmanagerConfig struct {
+	Host      string        `yaml:"host"`
+	Path      string        `yaml:"path"`
+	BasicAuth *BasicAuth    `yaml:"basicAuth"`
+	TLS       *TLSConfig    `yaml:"tls"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// amTarget is a configured Alertmanager paired with its generated API client.
+type amTarget struct {
+	name    string
+	client  *client.AlertmanagerAPI
+	timeout time.Duration
+
+	mu          sync.Mutex
+	latency     time.Duration
+	failures    int
+	nextAttempt time.Time
+}
+
+func (t *amTarget) recordLatency(d time.Duration) {
+	t.mu.Lock()
+	t.latency = d
+	t.mu.Unlock()
+}
+
+func (t *amTarget) Latency() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.latency
+}
+
+// maxTargetCooldown caps how long a persistently-failing target is skipped
+// before the dispatcher tries it again.
+const maxTargetCooldown = 5 * time.Minute
+
+// skipUntilHealthy reports whether now is still within this target's
+// cooldown window from a prior flush cycle's failures.
+func (t *amTarget) skipUntilHealthy(now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return now.Before(t.nextAttempt)
+}
+
+// recordFlushFailure carries this target's failure count across flush
+// cycles and backs off the next attempt so a known-bad Alertmanager stops
+// consuming a full retry budget, and blocking the shared dispatch loop,
+// on every tick.
+func (t *amTarget) recordFlushFailure(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures++
+	shift := t.failures
+	if shift > 8 {
+		shift = 8
+	}
+	cooldown := time.Second << uint(shift)
+	if cooldown > maxTargetCooldown {
+		cooldown = maxTargetCooldown
+	}
+	t.nextAttempt = now.Add(cooldown)
+}
+
+// recordFlushSuccess clears a target's failure state once it acks again.
+func (t *amTarget) recordFlushSuccess() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures = 0
+	t.nextAttempt = time.Time{}
+}
+
+// notifierMetrics counts dropped, sent and failed alerts. Only dropped is
+// surfaced on the daemon's metrics endpoint, through Dropped.
+type notifierMetrics struct {
+	dropped atomic.Int64
+	sent    atomic.Int64
+	failed  atomic.Int64
+}
+
+// queuedAlert pairs a queued alert with the callback to run once it has
+// actually been ACKed by an Alertmanager, so callers can gate resend logic
+// on real delivery instead of assuming success at enqueue time.
+type queuedAlert struct {
+	alert *models.PostableAlert
+	onAck func(key string)
+}
+
+// Notifier batches alerts produced by rule evaluations into a bounded,
+// deduplicated queue and fans them out to one or more Alertmanagers. It is
+// modeled on Prometheus's notifier.Manager: rule goroutines hand alerts over
+// through Enqueue, which is cheap and never blocks on the network, and a
+// background dispatcher owns all delivery I/O and retry behavior. ActiveAlerts
+// is the exception: it queries the Alertmanagers on the caller's goroutine.
+type Notifier struct {
+	mu       sync.Mutex
+	queue    map[string]*queuedAlert
+	queueCap int
+
+	targets []*amTarget
+	metrics notifierMetrics
+
+	promMetrics *Metrics
+}
+
+// NewNotifier builds a Notifier for the given Alertmanager configs. Each
+// target gets its own HTTP client so that per-target TLS and basic auth
+// settings don't leak across Alertmanagers. promMetrics may be nil, in
+// which case deliveries simply aren't reported to Prometheus.
+func NewNotifier(cfgs []AlertmanagerConfig, defaultTimeout time.Duration, queueCap int, promMetrics *Metrics) (*Notifier, error) {
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("at least one alertmanager must be configured")
+	}
+
+	targets := make([]*amTarget, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		timeout := cfg.Timeout
+		if timeout == 0 {
+			timeout = defaultTimeout
+		}
+
+		c, err := newAlertmanagerClient(cfg, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("configuring alertmanager %q: %w", cfg.Host, err)
+		}
+
+		targets = append(targets, &amTarget{
+			name:    cfg.Host,
+			client:  c,
+			timeout: timeout,
+		})
+	}
+
+	return &Notifier{
+		queue:       make(map[string]*queuedAlert),
+		queueCap:    queueCap,
+		targets:     targets,
+		promMetrics: promMetrics,
+	}, nil
+}
+
+func newAlertmanagerClient(cfg AlertmanagerConfig, timeout time.Duration) (*client.AlertmanagerAPI, error) {
+	httpClient := &http.Client{Timeout: timeout}
+	scheme := "http"
+
+	if cfg.TLS != nil {
+		tlsConfig := &tls.Config{InsecureSkipVerify: cfg.TLS.InsecureSkipVerify}
+
+		if cfg.TLS.CAFile != "" {
+			pool := x509.NewCertPool()
+			pem, err := os.ReadFile(cfg.TLS.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading caFile: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", cfg.TLS.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		if cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("loading client keypair: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		httpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+		scheme = "https"
+	}
+
+	path := cfg.Path
+	if path == "" {
+		path = "/api/v2/"
+	}
+
+	rt := httptransport.NewWithClient(cfg.Host, path, []string{scheme}, httpClient)
+	if cfg.BasicAuth != nil {
+		rt.DefaultAuthentication = httptransport.BasicAuth(cfg.BasicAuth.Username, cfg.BasicAuth.Password)
+	}
+
+	return client.New(rt, strfmt.Default), nil
+}
+
+// Enqueue merges alerts into the dedup queue, keyed by label-set, so that a
+// rule re-evaluating faster than the dispatcher drains just coalesces into
+// the latest value instead of piling up duplicate work. onAck, if non-nil,
+// is called with an alert's key once a flush has had it ACKed by at least
+// one Alertmanager — callers use this to gate resend logic on real
+// delivery instead of assuming success at enqueue time.
+func (n *Notifier) Enqueue(alerts models.PostableAlerts, onAck func(key string)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, a := range alerts {
+		k := key(a.Alert.Labels)
+		if _, exists := n.queue[k]; !exists && len(n.queue) >= n.queueCap {
+			n.metrics.dropped.Add(1)
+			slog.Warn("notifier queue full, dropping alert", "key", k, "cap", n.queueCap)
+			continue
+		}
+		n.queue[k] = &queuedAlert{alert: a, onAck: onAck}
+	}
+}
+
+// QueueLength reports the number of distinct alerts currently queued.
+func (n *Notifier) QueueLength() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.queue)
+}
+
+// Dropped reports the number of alerts dropped because the queue was full.
+func (n *Notifier) Dropped() int64 {
+	return n.metrics.dropped.Load()
+}
+
+// Run drains the queue in batches on the given interval until ctx is done.
+func (n *Notifier) Run(ctx context.Context, flushInterval time.Duration) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.flush(ctx)
+		}
+	}
+}
+
+func (n *Notifier) flush(ctx context.Context) {
+	n.mu.Lock()
+	if len(n.queue) == 0 {
+		n.mu.Unlock()
+		return
+	}
+	batch := make(models.PostableAlerts, 0, len(n.queue))
+	drained := make([]*queuedAlert, 0, len(n.queue))
+	for k, qa := range n.queue {
+		batch = append(batch, qa.alert)
+		drained = append(drained, qa)
+		delete(n.queue, k)
+	}
+	n.mu.Unlock()
+
+	var acked atomic.Bool
+	now := time.Now()
+	wg := sync.WaitGroup{}
+	wg.Add(len(n.targets))
+	for _, t := range n.targets {
+		go func(t *amTarget) {
+			defer wg.Done()
+			if t.skipUntilHealthy(now) {
+				slog.Debug("skipping known-unhealthy alertmanager target", "target", t.name)
+				return
+			}
+			if n.postWithRetry(ctx, t, batch) {
+				acked.Store(true)
+				t.recordFlushSuccess()
+			} else {
+				t.recordFlushFailure(time.Now())
+			}
+		}(t)
+	}
+	wg.Wait()
+
+	if acked.Load() {
+		n.metrics.sent.Add(int64(len(batch)))
+		slog.Info("alert batch delivered", "count", len(batch))
+		for _, qa := range drained {
+			if qa.onAck != nil {
+				qa.onAck(key(qa.alert.Alert.Labels))
+			}
+		}
+	} else {
+		n.metrics.failed.Add(int64(len(batch)))
+		slog.Error("alert batch failed on every alertmanager", "count", len(batch))
+	}
+
+	if n.promMetrics != nil {
+		n.promMetrics.recordNotification(acked.Load())
+	}
+}
+
+// postWithRetry POSTs batch to t, retrying with exponential backoff on
+// transport errors and 5xx responses. It returns true once t has ACKed.
+func (n *Notifier) postWithRetry(ctx context.Context, t *amTarget, batch models.PostableAlerts) bool {
+	const maxAttempts = 5
+	backoff := time.Second
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		postCtx, cancel := context.WithTimeout(ctx, t.timeout)
+		start := time.Now()
+		params := alert.NewPostAlertsParams().WithContext(postCtx).WithAlerts(batch)
+		resp, err := t.client.Alert.PostAlerts(params)
+		cancel()
+		latency := time.Since(start)
+		t.recordLatency(latency)
+		if n.promMetrics != nil {
+			n.promMetrics.recordLatency(t.name, latency)
+		}
+
+		if err == nil && resp.IsSuccess() {
+			return true
+		}
+
+		retryable := err != nil
+		if resp != nil && resp.Code() >= 500 {
+			retryable = true
+		}
+		// The generated client returns non-2xx statuses as errors; a 4xx
+		// status will not succeed on retry.
+		var badRequest *alert.PostAlertsBadRequest
+		var apiErr *runtime.APIError
+		if errors.As(err, &badRequest) || (errors.As(err, &apiErr) && apiErr.Code < 500) {
+			retryable = false
+		}
+
+		slog.Warn("alertmanager post failed",
+			"target", t.name,
+			"attempt", attempt,
+			"error", err,
+		)
+
+		if !retryable || attempt == maxAttempts {
+			return false
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return false
+}
+
+// ActiveAlerts queries the configured Alertmanagers for alerts matching
+// ruleName, trying each target in turn until one answers.
+func (n *Notifier) ActiveAlerts(ctx context.Context, ruleName string) (models.GettableAlerts, error) {
+	active := true
+	var lastErr error
+
+	for _, t := range n.targets {
+		getCtx, cancel := context.WithTimeout(ctx, t.timeout)
+		params := alert.NewGetAlertsParams().
+			WithContext(getCtx).
+			WithActive(&active).
+			WithFilter([]string{fmt.Sprintf("alertname=%s", ruleName)})
+
+		resp, err := t.client.Alert.GetAlerts(params)
+		cancel()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return resp.Payload, nil
+	}
+	return nil, lastErr
+}
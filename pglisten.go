@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgresDrivers are the -driver values that speak LISTEN/NOTIFY. Any other
+// driver must reject notifyChannel at config load rather than silently
+// falling back to pure polling.
+var postgresDrivers = map[string]bool{
+	"pgx":        true,
+	"postgres":   true,
+	"postgresql": true,
+}
+
+// validateNotifyChannel rejects rules that ask for event-driven evaluation
+// against a non-Postgres driver, since LISTEN/NOTIFY is a Postgres extension.
+func validateNotifyChannel(r AlertRule, driver string) error {
+	if r.NotifyChannel != "" && !postgresDrivers[driver] {
+		return fmt.Errorf("rule %q: notifyChannel requires a postgres driver, got %q", r.Name, driver)
+	}
+	return nil
+}
+
+// PgNotifyListener subscribes to a Postgres NOTIFY channel and coalesces
+// incoming notifications onto a buffered signal channel, reconnecting with
+// backoff whenever the underlying connection drops.
+type PgNotifyListener struct {
+	conninfo string
+	channel  string
+	signal   chan struct{}
+}
+
+// NewPgNotifyListener builds a listener for channel using conninfo, the same
+// connection string configured for the rule's database.
+func NewPgNotifyListener(conninfo, channel string) *PgNotifyListener {
+	return &PgNotifyListener{
+		conninfo: conninfo,
+		channel:  channel,
+		signal:   make(chan struct{}, 1),
+	}
+}
+
+// Notifications returns a channel that receives a value each time a NOTIFY
+// arrives on the subscribed channel. Sends are non-blocking and coalesced,
+// so a burst of NOTIFYs collapses into a single pending re-evaluation.
+func (l *PgNotifyListener) Notifications() <-chan struct{} {
+	return l.signal
+}
+
+// Run connects and listens until ctx is cancelled, reconnecting with
+// exponential backoff on dropped connections or listen failures.
+func (l *PgNotifyListener) Run(ctx context.Context) {
+	const maxBackoff = time.Minute
+	backoff := time.Second
+
+	for ctx.Err() == nil {
+		listener := pq.NewListener(l.conninfo, 10*time.Second, maxBackoff, func(_ pq.ListenerEventType, err error) {
+			if err != nil {
+				slog.Warn("pg listener event", "channel", l.channel, "error", err)
+			}
+		})
+
+		if err := listener.Listen(l.channel); err != nil {
+			slog.Error("failed to listen on channel", "channel", l.channel, "error", err)
+			listener.Close()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < maxBackoff {
+				backoff *= 2
+			}
+			continue
+		}
+
+		slog.Info("listening for notifications", "channel", l.channel)
+		backoff = time.Second
+		l.consume(ctx, listener)
+		listener.Close()
+	}
+}
+
+// consume pumps listener.Notify into the signal channel and keeps the
+// connection alive with periodic pings until ctx is cancelled or
+// listener.Notify is closed. A dropped connection does not end it: pq.Listener
+// reconnects on its own and then delivers a nil notification.
+func (l *PgNotifyListener) consume(ctx context.Context, listener *pq.Listener) {
+	ticker := time.NewTicker(90 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n, ok := <-listener.Notify:
+			if !ok {
+				return
+			}
+			if n == nil {
+				// nil notification means the driver silently reconnected;
+				// treat it as a signal since we may have missed NOTIFYs.
+			}
+			l.notify()
+		case <-ticker.C:
+			go func() {
+				if err := listener.Ping(); err != nil {
+					slog.Warn("pg listener ping failed", "channel", l.channel, "error", err)
+				}
+			}()
+		}
+	}
+}
+
+func (l *PgNotifyListener) notify() {
+	select {
+	case l.signal <- struct{}{}:
+	default:
+	}
+}
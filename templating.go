@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are available to every label/annotation template, on top of
+// text/template's builtins.
+var templateFuncs = template.FuncMap{
+	"float64": func(s string) (float64, error) {
+		return strconv.ParseFloat(s, 64)
+	},
+}
+
+// TemplateData is the value every label/annotation template is executed
+// against, giving rule authors the same ergonomics as Prometheus rules.
+type TemplateData struct {
+	Row   map[string]string
+	Query string
+	Now   time.Time
+}
+
+// compiledRule holds a rule's parsed label/annotation templates, built once
+// so evaluation never re-parses them.
+type compiledRule struct {
+	labels      map[string]*template.Template
+	annotations map[string]*template.Template
+}
+
+// compileRule parses r's labels/annotations templates, folding LabelCols and
+// AnnotationCols in as sugar for `{{ .Row.col }}`. A parse error here is
+// caught at config load rather than during evaluation.
+func compileRule(r AlertRule) (*compiledRule, error) {
+	cr := &compiledRule{
+		labels:      make(map[string]*template.Template, len(r.Labels)+len(r.LabelCols)),
+		annotations: make(map[string]*template.Template, len(r.Annotations)+len(r.AnnotationCols)),
+	}
+
+	for _, col := range r.LabelCols {
+		if err := cr.addLabel(col, fmt.Sprintf("{{ .Row.%s }}", col)); err != nil {
+			return nil, err
+		}
+	}
+	for name, tmpl := range r.Labels {
+		if err := cr.addLabel(name, tmpl); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, col := range r.AnnotationCols {
+		if err := cr.addAnnotation(col, fmt.Sprintf("{{ .Row.%s }}", col)); err != nil {
+			return nil, err
+		}
+	}
+	for name, tmpl := range r.Annotations {
+		if err := cr.addAnnotation(name, tmpl); err != nil {
+			return nil, err
+		}
+	}
+
+	return cr, nil
+}
+
+func (cr *compiledRule) addLabel(name, raw string) error {
+	t, err := template.New(name).Funcs(templateFuncs).Parse(raw)
+	if err != nil {
+		return fmt.Errorf("label %q: %w", name, err)
+	}
+	cr.labels[name] = t
+	return nil
+}
+
+func (cr *compiledRule) addAnnotation(name, raw string) error {
+	t, err := template.New(name).Funcs(templateFuncs).Parse(raw)
+	if err != nil {
+		return fmt.Errorf("annotation %q: %w", name, err)
+	}
+	cr.annotations[name] = t
+	return nil
+}
+
+// Execute renders every compiled label/annotation template against data. A
+// template that fails to execute is logged and skipped rather than aborting
+// the row.
+func (cr *compiledRule) Execute(ruleName string, data TemplateData) (labels, annotations map[string]string) {
+	labels = make(map[string]string, len(cr.labels))
+	for name, t := range cr.labels {
+		val, err := renderTemplate(t, data)
+		if err != nil {
+			slog.Error("error executing label template", "name", ruleName, "label", name, "error", err)
+			continue
+		}
+		if val != "" {
+			labels[name] = val
+		}
+	}
+
+	annotations = make(map[string]string, len(cr.annotations))
+	for name, t := range cr.annotations {
+		val, err := renderTemplate(t, data)
+		if err != nil {
+			slog.Error("error executing annotation template", "name", ruleName, "annotation", name, "error", err)
+			continue
+		}
+		if val != "" {
+			annotations[name] = val
+		}
+	}
+
+	return labels, annotations
+}
+
+func renderTemplate(t *template.Template, data TemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}